@@ -1,8 +1,10 @@
 package network
 
 import (
+	"context"
 	"errors"
-	"runtime"
+	"strings"
+	"sync"
 )
 
 // MessageTracker tracks a configurable fixed amount of messages.
@@ -16,13 +18,125 @@ type MessageTracker interface {
 	Message(id string) (message *Message, err error)
 	// Messages returns messages in FIFO order
 	Messages() (messages []*Message)
+	// Iterator returns a MessageIterator over a copy of all tracked messages,
+	// taken when Iterator is called, in the same order as Messages (FIFO, or
+	// least recently used first under the LRU policy).
+	Iterator() (iterator MessageIterator)
+	// PrefixIterator returns a MessageIterator over tracked messages whose ID
+	// starts with idPrefix, in the same order as Iterator.
+	PrefixIterator(idPrefix string) (iterator MessageIterator)
+	// PeerIterator returns a MessageIterator over tracked messages received
+	// from peerID, in the same order as Iterator.
+	PeerIterator(peerID string) (iterator MessageIterator)
+}
+
+// MessageIterator is a stateful cursor over the messages tracked at the
+// moment the iterator was created. Concurrent Add/Delete calls on the
+// Tracker do not affect an iterator already in flight. Call Next to advance
+// before the first and every subsequent Message call, and Close once done.
+type MessageIterator interface {
+	// Next advances the cursor and reports whether a message is available.
+	Next() (ok bool)
+	// Message returns the message at the current cursor position. It is only
+	// valid after a call to Next returned true.
+	Message() (message *Message)
+	// Close releases the iterator's snapshot.
+	Close()
+}
+
+// node is a single entry in the Tracker's intrusive doubly-linked list.
+type node struct {
+	msg  *Message
+	prev *node
+	next *node
+	freq int
+}
+
+// EvictionPolicy selects which tracked message Add evicts to make room once
+// the Tracker is full.
+type EvictionPolicy int
+
+const (
+	// FIFO evicts the oldest added message, regardless of access pattern.
+	FIFO EvictionPolicy = iota
+	// LRU evicts the least recently used message, bumping a message to the
+	// newest position on every Message hit, so Messages and the iterators
+	// list messages least recently used first.
+	LRU
+	// LFU evicts the least frequently used message, breaking ties by age.
+	LFU
+)
+
+// Option configures a Tracker built with NewMessageTrackerWithOptions.
+type Option func(*Tracker)
+
+// WithEvictionPolicy sets which message Add evicts once the Tracker is full.
+// The default, used by NewMessageTracker and NewMessageTrackerWithNotify, is FIFO.
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(t *Tracker) {
+		t.policy = policy
+	}
+}
+
+// OnEvict registers a callback invoked synchronously, under the Tracker's
+// lock, whenever a message leaves the tracker: evicted to make room for a
+// new one, or explicitly removed via Delete.
+func OnEvict(fn func(*Message)) Option {
+	return func(t *Tracker) {
+		t.onEvict = fn
+	}
+}
+
+// OnAdd registers a callback invoked synchronously, under the Tracker's
+// lock, whenever a new message is added. It is not called for duplicate Adds.
+func OnAdd(fn func(*Message)) Option {
+	return func(t *Tracker) {
+		t.onAdd = fn
+	}
+}
+
+// Stats holds running counters of Tracker activity, returned by Stats().
+type Stats struct {
+	Adds      int
+	DedupHits int
+	Evictions int
+	Deletes   int
+}
+
+// waitState is what a pending WaitFor call blocks on for a single ID: ch is
+// closed once the ID either arrives via Add or is evicted/deleted, and err
+// (set before ch is closed, so safe to read after a receive) distinguishes
+// the two. refs counts the WaitFor calls currently registered on ch; it lets
+// a WaitFor that gives up on ctx.Done remove its entry from pending without
+// pulling the rug out from under other callers still waiting on the same ID.
+type waitState struct {
+	ch   chan struct{}
+	err  error
+	refs int
 }
 
 // Tracker represents all the fields needed for the implementation.
+// Messages are kept in a doubly-linked list ordered head (oldest) to tail
+// (newest), with a map from ID to node giving O(1) lookup, insertion and
+// removal. head and tail are sentinel nodes that are never removed, so
+// linking/unlinking never needs to special-case an empty list.
+//
+// mu guards every other field of Tracker; Add, Delete, Message and Messages may all
+// be called concurrently.
 type Tracker struct {
-	msgMap  map[string]*Message
-	msgList []*Message
-	length  int
+	msgMap map[string]*node
+	head   *node
+	tail   *node
+	size   int
+	length int
+
+	mu      sync.RWMutex
+	pending map[string]*waitState
+
+	policy  EvictionPolicy
+	onEvict func(*Message)
+	onAdd   func(*Message)
+	stats   Stats
 }
 
 // asserting that Tracker struct implements interface MessageTracker.
@@ -31,103 +145,351 @@ var _ MessageTracker = &Tracker{}
 // ErrMessageNotFound is an error returned by MessageTracker when a message with specified id is not found
 var ErrMessageNotFound = errors.New("message not found")
 
-// NewMessageTracker creates Tracker
-func NewMessageTracker(length int) MessageTracker {
+// ErrMessageEvicted is returned by WaitFor when a waited-for ID is deleted or
+// evicted from the tracker before it is ever observed via Add.
+var ErrMessageEvicted = errors.New("message evicted before it arrived")
+
+func newTracker(length int) *Tracker {
+	head := &node{}
+	tail := &node{}
+	head.next = tail
+	tail.prev = head
+
 	return &Tracker{
-		msgMap:  make(map[string]*Message),
-		msgList: make([]*Message, 0),
+		msgMap:  make(map[string]*node),
+		head:    head,
+		tail:    tail,
 		length:  length,
+		pending: make(map[string]*waitState),
 	}
 }
 
+// NewMessageTracker creates Tracker
+func NewMessageTracker(length int) MessageTracker {
+	return newTracker(length)
+}
+
+// NewMessageTrackerWithNotify creates a Tracker and returns the concrete type
+// so callers also get WaitFor, the round-trip helper that blocks until a set
+// of IDs have been observed via Add.
+func NewMessageTrackerWithNotify(length int) *Tracker {
+	return newTracker(length)
+}
+
+// NewMessageTrackerWithOptions creates a Tracker configured with the given
+// Options, e.g. WithEvictionPolicy, OnEvict or OnAdd. This is the
+// general-purpose constructor for using Tracker as a gossip/seen-cache
+// primitive beyond a strict FIFO window.
+func NewMessageTrackerWithOptions(length int, opts ...Option) *Tracker {
+	t := newTracker(length)
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
 // Add MessageTracker implementation: handling duplicates, full Tracker and normal addition.
 func (t *Tracker) Add(message *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if t.messageExists(message.ID) {
+		t.stats.DedupHits++
 		return nil
 	}
 
 	if t.isTrackerFull() {
-		delete(t.msgMap, t.msgList[0].ID)
-		t.msgList = t.msgList[1:]
+		victim := t.evictionVictim()
+		t.removeNode(victim)
+		t.stats.Evictions++
+		t.signal(victim.msg.ID, ErrMessageEvicted)
+		if t.onEvict != nil {
+			t.onEvict(victim.msg)
+		}
 	}
 
-	t.msgMap[message.ID] = message
-	t.msgList = append(t.msgList, message)
+	n := &node{msg: message, freq: 1}
+	t.linkBeforeTail(n)
+	t.msgMap[message.ID] = n
+	t.size++
+	t.stats.Adds++
+	t.signal(message.ID, nil)
+	if t.onAdd != nil {
+		t.onAdd(message)
+	}
 
 	return nil
 }
 
 // Delete MessageTracker implementation: deleting the Message by ID from map and the list.
 func (t *Tracker) Delete(id string) error {
-	if !t.messageExists(id) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, exists := t.msgMap[id]
+	if !exists {
+		// Still wake up any WaitFor callers pending on id: from their
+		// perspective, a Delete means this ID is being given up on and will
+		// never arrive via Add.
+		t.signal(id, ErrMessageEvicted)
 		return ErrMessageNotFound
 	}
 
-	msgIndex := t.getMessageIndex(id)
-	t.msgList = append(t.msgList[:msgIndex], t.msgList[msgIndex+1:]...)
-	delete(t.msgMap, id)
+	t.removeNode(n)
+	t.stats.Deletes++
+	t.signal(id, ErrMessageEvicted)
+	if t.onEvict != nil {
+		t.onEvict(n.msg)
+	}
 
 	return nil
 }
 
 // Message MessageTracker implementation: getting the Message by ID from the map.
 func (t *Tracker) Message(id string) (*Message, error) {
-	msg, exists := t.msgMap[id]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, exists := t.msgMap[id]
 	if !exists {
 		return nil, ErrMessageNotFound
 	}
 
-	return msg, nil
+	n.freq++
+	if t.policy == LRU {
+		t.unlink(n)
+		t.linkBeforeTail(n)
+	}
+
+	return n.msg, nil
+}
+
+// Stats returns a snapshot of the Tracker's running activity counters.
+func (t *Tracker) Stats() Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.stats
 }
 
 // Messages MessageTracker implementation: returning all the Message FIFO ordered
 func (t *Tracker) Messages() []*Message {
-	return t.msgList
+	return t.snapshot(nil)
 }
 
-func (t *Tracker) isTrackerFull() bool {
-	return len(t.msgMap) >= t.length
+// snapshot walks the list head->tail under a read lock, collecting messages
+// for which keep returns true (or every message, if keep is nil) into a
+// single pre-sized slice.
+func (t *Tracker) snapshot(keep func(*Message) bool) []*Message {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	messages := make([]*Message, 0, t.size)
+	for n := t.head.next; n != t.tail; n = n.next {
+		if keep == nil || keep(n.msg) {
+			messages = append(messages, n.msg)
+		}
+	}
+
+	return messages
 }
 
-func (t *Tracker) messageExists(id string) bool {
-	_, exists := t.msgMap[id]
-	return exists
+// idWaiter pairs an ID with the waitState WaitFor registered it under, so a
+// waiter that gives up can release just that ID's registration.
+type idWaiter struct {
+	id string
+	ws *waitState
 }
 
-func getMessageIndexByBatch(id string, list []*Message, from int, indexChan chan<- int) {
-	for i := 0; i < len(list); i++ {
-		if list[i].ID == id {
-			indexChan <- from + i
-			return
+// WaitFor blocks until every one of ids has either been added to the tracker
+// or the context is done, whichever happens first. An ID that is already
+// present when WaitFor is called is treated as already arrived. If an ID is
+// deleted or evicted before it arrives, WaitFor returns ErrMessageEvicted.
+// This makes Tracker usable as a synchronization primitive in tests where a
+// producer pushes messages and a consumer must confirm they all landed
+// within a deadline.
+func (t *Tracker) WaitFor(ctx context.Context, ids ...string) error {
+	t.mu.Lock()
+	waiters := make([]idWaiter, 0, len(ids))
+	for _, id := range ids {
+		if t.messageExists(id) {
+			continue
+		}
+
+		ws, exists := t.pending[id]
+		if !exists {
+			ws = &waitState{ch: make(chan struct{})}
+			t.pending[id] = ws
+		}
+		ws.refs++
+		waiters = append(waiters, idWaiter{id: id, ws: ws})
+	}
+	t.mu.Unlock()
+
+	for i, w := range waiters {
+		select {
+		case <-w.ws.ch:
+			t.releaseWaiter(w.id, w.ws)
+			if w.ws.err != nil {
+				t.releaseWaiters(waiters[i+1:])
+				return w.ws.err
+			}
+		case <-ctx.Done():
+			t.releaseWaiters(waiters[i:])
+			return ctx.Err()
 		}
 	}
+
+	return nil
 }
 
-func (t *Tracker) getMessageIndex(id string) int {
-	// uses NumCPU as amount of threads
-	threads := runtime.NumCPU()
-	msgLength := len(t.msgList)
-	indexChan := make(chan int)
-	defer close(indexChan)
+// releaseWaiters releases every waiter's registration, see releaseWaiter.
+func (t *Tracker) releaseWaiters(waiters []idWaiter) {
+	for _, w := range waiters {
+		t.releaseWaiter(w.id, w.ws)
+	}
+}
 
-	// if there is fewer messages that goroutines, then only spawn 1 threads
-	batch := msgLength / threads
-	if batch == 0 {
-		threads = 1
-		batch = msgLength
+// releaseWaiter drops this WaitFor call's reference to ws. Once ws is
+// referenced by nobody, it is removed from pending so an ID that never
+// arrives doesn't leak an entry forever; a ws already signalled and removed
+// by Add/Delete is left alone.
+func (t *Tracker) releaseWaiter(id string, ws *waitState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ws.refs--
+	if ws.refs > 0 {
+		return
 	}
 
-	// splits in batches for the linear search
-	for i := 0; i < threads; i++ {
-		start := i * batch
-		end := start + batch
-		if i == threads-1 {
-			end = msgLength
-		}
+	if current, exists := t.pending[id]; exists && current == ws {
+		delete(t.pending, id)
+	}
+}
+
+// Iterator MessageTracker implementation: snapshotting the current list
+// order into a MessageIterator.
+func (t *Tracker) Iterator() MessageIterator {
+	return newMessageIterator(t.snapshot(nil))
+}
+
+// PrefixIterator MessageTracker implementation: snapshotting messages whose
+// ID starts with idPrefix into a MessageIterator.
+func (t *Tracker) PrefixIterator(idPrefix string) MessageIterator {
+	return newMessageIterator(t.snapshot(func(m *Message) bool {
+		return strings.HasPrefix(m.ID, idPrefix)
+	}))
+}
+
+// PeerIterator MessageTracker implementation: snapshotting messages received
+// from peerID into a MessageIterator.
+func (t *Tracker) PeerIterator(peerID string) MessageIterator {
+	return newMessageIterator(t.snapshot(func(m *Message) bool {
+		return m.PeerID == peerID
+	}))
+}
 
-		go getMessageIndexByBatch(id, t.msgList[start:end], start, indexChan)
+// messageIterator is a MessageIterator over a fixed snapshot of messages
+// taken at construction time.
+type messageIterator struct {
+	messages []*Message
+	pos      int
+}
+
+func newMessageIterator(messages []*Message) *messageIterator {
+	return &messageIterator{messages: messages, pos: -1}
+}
+
+// Next advances the cursor, reporting whether a message is available.
+func (it *messageIterator) Next() bool {
+	if it.pos+1 >= len(it.messages) {
+		return false
 	}
 
-	msgIndex := <-indexChan
-	return msgIndex
+	it.pos++
+	return true
+}
+
+// Message returns the message at the current cursor position.
+func (it *messageIterator) Message() *Message {
+	if it.pos < 0 || it.pos >= len(it.messages) {
+		return nil
+	}
+
+	return it.messages[it.pos]
+}
+
+// Close releases the iterator's snapshot.
+func (it *messageIterator) Close() {
+	it.messages = nil
+	it.pos = 0
+}
+
+// signal wakes up any WaitFor callers pending on id, must be called with mu held.
+func (t *Tracker) signal(id string, err error) {
+	ws, exists := t.pending[id]
+	if !exists {
+		return
+	}
+
+	ws.err = err
+	close(ws.ch)
+	delete(t.pending, id)
+}
+
+func (t *Tracker) isTrackerFull() bool {
+	return t.size >= t.length
+}
+
+// removeNode unlinks n from the list and removes it from msgMap, must be
+// called with mu held.
+func (t *Tracker) removeNode(n *node) {
+	t.unlink(n)
+	delete(t.msgMap, n.msg.ID)
+	t.size--
+}
+
+// evictionVictim picks the node Add should remove to make room, according
+// to the configured EvictionPolicy. Must be called with mu held on a full,
+// non-empty Tracker.
+func (t *Tracker) evictionVictim() *node {
+	switch t.policy {
+	case LFU:
+		// Walk head->tail (oldest to newest) so that on a frequency tie the
+		// oldest entry wins, instead of ranging over msgMap whose iteration
+		// order is randomized.
+		victim := t.head.next
+		for n := victim.next; n != t.tail; n = n.next {
+			if n.freq < victim.freq {
+				victim = n
+			}
+		}
+		return victim
+	default: // FIFO, LRU
+		return t.head.next
+	}
+}
+
+func (t *Tracker) messageExists(id string) bool {
+	_, exists := t.msgMap[id]
+	return exists
+}
+
+// linkBeforeTail inserts n immediately before the tail sentinel, making it the newest entry.
+func (t *Tracker) linkBeforeTail(n *node) {
+	prev := t.tail.prev
+	prev.next = n
+	n.prev = prev
+	n.next = t.tail
+	t.tail.prev = n
+}
+
+// unlink removes n from the list, joining its neighbours together.
+func (t *Tracker) unlink(n *node) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+	n.prev = nil
+	n.next = nil
 }
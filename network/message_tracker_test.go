@@ -1,9 +1,11 @@
 package network_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"testing"
+	"time"
 
 	"github.com/ChainSafe/gossamer-go-interview/network"
 	"github.com/stretchr/testify/assert"
@@ -223,6 +225,317 @@ func TestMessageTracker_DeleteLastMessage(t *testing.T) {
 	})
 }
 
+func TestTracker_WaitFor(t *testing.T) {
+	t.Run("already present returns immediately", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithNotify(5)
+		err := mt.Add(generateMessage(0))
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err = mt.WaitFor(ctx, generateID(0))
+		assert.NoError(t, err)
+	})
+
+	t.Run("unblocks once Add arrives", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithNotify(5)
+
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			done <- mt.WaitFor(ctx, generateID(0), generateID(1))
+		}()
+
+		err := mt.Add(generateMessage(0))
+		assert.NoError(t, err)
+		err = mt.Add(generateMessage(1))
+		assert.NoError(t, err)
+
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("context deadline exceeded when ID never arrives", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithNotify(5)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := mt.WaitFor(ctx, generateID(0))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("evicted before arrival", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithNotify(5)
+
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			done <- mt.WaitFor(ctx, generateID(0))
+		}()
+
+		select {
+		case err := <-done:
+			t.Fatalf("WaitFor returned early with %v before id 0 was added or deleted", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		// id 0 was never added, so this is a no-op from the tracker's point of
+		// view, but it still gives up on any pending WaitFor callers for it.
+		err := mt.Delete(generateID(0))
+		assert.ErrorIs(t, err, network.ErrMessageNotFound)
+
+		assert.ErrorIs(t, <-done, network.ErrMessageEvicted)
+	})
+
+	t.Run("timing out does not cancel other waiters on the same ID", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithNotify(5)
+
+		longDone := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			longDone <- mt.WaitFor(ctx, generateID(0))
+		}()
+
+		// give the long-lived waiter time to register before the short one times out.
+		time.Sleep(10 * time.Millisecond)
+
+		shortCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := mt.WaitFor(shortCtx, generateID(0))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// if the timed-out waiter above had torn down the shared pending
+		// registration instead of just releasing its own reference, this Add
+		// would never reach the still-waiting long-lived goroutine.
+		err = mt.Add(generateMessage(0))
+		assert.NoError(t, err)
+
+		assert.NoError(t, <-longDone)
+	})
+}
+
+func drainIterator(it network.MessageIterator) []*network.Message {
+	defer it.Close()
+
+	var messages []*network.Message
+	for it.Next() {
+		messages = append(messages, it.Message())
+	}
+
+	return messages
+}
+
+func TestMessageTracker_Iterator(t *testing.T) {
+	t.Run("walks all messages in FIFO order", func(t *testing.T) {
+		length := 5
+		mt := network.NewMessageTracker(length)
+
+		for i := 0; i < length; i++ {
+			err := mt.Add(generateMessage(i))
+			assert.NoError(t, err)
+		}
+
+		messages := drainIterator(mt.Iterator())
+		assert.Equal(t, mt.Messages(), messages)
+	})
+
+	t.Run("empty tracker", func(t *testing.T) {
+		mt := network.NewMessageTracker(5)
+
+		it := mt.Iterator()
+		assert.False(t, it.Next())
+		assert.Nil(t, it.Message())
+		it.Close()
+	})
+
+	t.Run("snapshot is unaffected by later mutation", func(t *testing.T) {
+		length := 5
+		mt := network.NewMessageTracker(length)
+
+		for i := 0; i < length; i++ {
+			err := mt.Add(generateMessage(i))
+			assert.NoError(t, err)
+		}
+
+		it := mt.Iterator()
+
+		err := mt.Delete(generateID(0))
+		assert.NoError(t, err)
+		err = mt.Add(generateMessage(length))
+		assert.NoError(t, err)
+
+		assert.Equal(t, []*network.Message{
+			generateMessage(0),
+			generateMessage(1),
+			generateMessage(2),
+			generateMessage(3),
+			generateMessage(4),
+		}, drainIterator(it))
+	})
+}
+
+func TestMessageTracker_PrefixIterator(t *testing.T) {
+	t.Run("only matching prefix", func(t *testing.T) {
+		length := 12
+		mt := network.NewMessageTracker(length)
+
+		for i := 0; i < length; i++ {
+			err := mt.Add(generateMessage(i))
+			assert.NoError(t, err)
+		}
+
+		messages := drainIterator(mt.PrefixIterator("someID1"))
+		assert.Equal(t, []*network.Message{
+			generateMessage(1),
+			generateMessage(10),
+			generateMessage(11),
+		}, messages)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		mt := network.NewMessageTracker(5)
+		err := mt.Add(generateMessage(0))
+		assert.NoError(t, err)
+
+		assert.Empty(t, drainIterator(mt.PrefixIterator("noSuchID")))
+	})
+}
+
+func TestMessageTracker_PeerIterator(t *testing.T) {
+	t.Run("only matching peer", func(t *testing.T) {
+		length := 5
+		mt := network.NewMessageTracker(length)
+
+		for i := 0; i < length; i++ {
+			err := mt.Add(generateMessage(i))
+			assert.NoError(t, err)
+		}
+
+		messages := drainIterator(mt.PeerIterator("somePeerID2"))
+		assert.Equal(t, []*network.Message{generateMessage(2)}, messages)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		mt := network.NewMessageTracker(5)
+		err := mt.Add(generateMessage(0))
+		assert.NoError(t, err)
+
+		assert.Empty(t, drainIterator(mt.PeerIterator("noSuchPeer")))
+	})
+}
+
+func TestMessageTracker_EvictionPolicy(t *testing.T) {
+	t.Run("FIFO evicts oldest regardless of access", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithOptions(3, network.WithEvictionPolicy(network.FIFO))
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, mt.Add(generateMessage(i)))
+		}
+
+		// Accessing id 0 should not save it from FIFO eviction.
+		_, err := mt.Message(generateID(0))
+		assert.NoError(t, err)
+
+		assert.NoError(t, mt.Add(generateMessage(3)))
+
+		_, err = mt.Message(generateID(0))
+		assert.ErrorIs(t, err, network.ErrMessageNotFound)
+	})
+
+	t.Run("LRU evicts least recently used", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithOptions(3, network.WithEvictionPolicy(network.LRU))
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, mt.Add(generateMessage(i)))
+		}
+
+		// Touch id 0, making id 1 the least recently used.
+		_, err := mt.Message(generateID(0))
+		assert.NoError(t, err)
+
+		assert.NoError(t, mt.Add(generateMessage(3)))
+
+		_, err = mt.Message(generateID(1))
+		assert.ErrorIs(t, err, network.ErrMessageNotFound)
+
+		_, err = mt.Message(generateID(0))
+		assert.NoError(t, err)
+	})
+
+	t.Run("LFU evicts least frequently used", func(t *testing.T) {
+		mt := network.NewMessageTrackerWithOptions(3, network.WithEvictionPolicy(network.LFU))
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, mt.Add(generateMessage(i)))
+		}
+
+		// id 0 and id 2 are accessed, id 1 is left at its initial frequency.
+		_, err := mt.Message(generateID(0))
+		assert.NoError(t, err)
+		_, err = mt.Message(generateID(2))
+		assert.NoError(t, err)
+
+		assert.NoError(t, mt.Add(generateMessage(3)))
+
+		_, err = mt.Message(generateID(1))
+		assert.ErrorIs(t, err, network.ErrMessageNotFound)
+	})
+}
+
+func TestMessageTracker_Hooks(t *testing.T) {
+	t.Run("OnAdd and OnEvict fire for new messages and overflow eviction", func(t *testing.T) {
+		var added, evicted []*network.Message
+		mt := network.NewMessageTrackerWithOptions(2,
+			network.OnAdd(func(m *network.Message) { added = append(added, m) }),
+			network.OnEvict(func(m *network.Message) { evicted = append(evicted, m) }),
+		)
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, mt.Add(generateMessage(i)))
+		}
+
+		assert.Equal(t, []*network.Message{generateMessage(0), generateMessage(1), generateMessage(2)}, added)
+		assert.Equal(t, []*network.Message{generateMessage(0)}, evicted)
+
+		assert.NoError(t, mt.Delete(generateID(1)))
+		assert.Equal(t, []*network.Message{generateMessage(0), generateMessage(1)}, evicted)
+	})
+
+	t.Run("OnAdd not called for duplicates", func(t *testing.T) {
+		var added []*network.Message
+		mt := network.NewMessageTrackerWithOptions(5, network.OnAdd(func(m *network.Message) { added = append(added, m) }))
+
+		assert.NoError(t, mt.Add(generateMessage(0)))
+		assert.NoError(t, mt.Add(generateMessage(0)))
+
+		assert.Equal(t, []*network.Message{generateMessage(0)}, added)
+	})
+}
+
+func TestMessageTracker_Stats(t *testing.T) {
+	mt := network.NewMessageTrackerWithOptions(2)
+
+	assert.NoError(t, mt.Add(generateMessage(0)))
+	assert.NoError(t, mt.Add(generateMessage(0))) // dedup hit
+	assert.NoError(t, mt.Add(generateMessage(1)))
+	assert.NoError(t, mt.Add(generateMessage(2))) // evicts id 0
+
+	assert.NoError(t, mt.Delete(generateID(1)))
+	err := mt.Delete(generateID(1))
+	assert.ErrorIs(t, err, network.ErrMessageNotFound)
+
+	assert.Equal(t, network.Stats{
+		Adds:      3,
+		DedupHits: 1,
+		Evictions: 1,
+		Deletes:   1,
+	}, mt.Stats())
+}
+
 func BenchmarkTestTrackerAddAndGetAllMessages_10000(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		length := 10000
@@ -314,3 +627,38 @@ func BenchmarkTestTrackerAddAndDeletingSome_100000(b *testing.B) {
 		_ = mt.Messages()
 	}
 }
+
+// BenchmarkTestTrackerLinkedListDelete_100000 exercises the same 100k
+// messages / 5% deletion workload as BenchmarkTestTrackerAddAndDeletingSome_100000,
+// but first fills the tracker to capacity so every Add of the second 100k
+// messages also evicts the oldest message. With the O(1) intrusive linked list, Add/Delete/evict no longer
+// fork goroutines to linear-scan msgList, so this stays flat as length grows
+// instead of degrading with the old map+slice+goroutine-scan design.
+func BenchmarkTestTrackerLinkedListDelete_100000(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		length := 100000
+		mt := network.NewMessageTracker(length)
+		idsToDelete := make([]string, 0)
+
+		for j := 0; j < length; j++ {
+			_ = mt.Add(generateMessageWithRandomUUID(j))
+		}
+
+		for j := length; j < length*2; j++ {
+			msg := generateMessageWithRandomUUID(j)
+			_ = mt.Add(msg)
+			if j%20 == 0 {
+				idsToDelete = append(idsToDelete, msg.ID)
+			}
+		}
+
+		for _, id := range idsToDelete {
+			err := mt.Delete(id)
+			if err != nil {
+				b.Fatal("wrong ID")
+			}
+		}
+
+		_ = mt.Messages()
+	}
+}